@@ -0,0 +1,144 @@
+// Package httpencoding is the content negotiation shared by every endpoint
+// that serves a protobuf message - currently the slice endpoint, with the
+// stitch endpoints meant to adopt it once they live in a buildable tree
+// again (see the stitch registrations in api/server/http.go). Pulling it out
+// of sliceController means wiring it into another handler is an import, not
+// a second copy of the MIME-matching and marshaling logic.
+package httpencoding
+
+import (
+	"encoding/binary"
+	"math"
+	"net/http"
+
+	"github.com/equinor/oneseismic/api/oneseismic"
+	"github.com/kataras/iris/v12"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// The encodings a negotiating endpoint can produce, picked from the Accept
+// header, with JSON as the fallback for clients that don't negotiate.
+const (
+	JSON     = "application/json"
+	Protobuf = "application/x-protobuf"
+	Msgpack  = "application/msgpack"
+	Octet    = "application/octet-stream"
+	Stream   = "application/x-oneseismic-stream"
+)
+
+// Negotiate walks the Accept header lines in the order they were sent and,
+// within each line, tries Stream, Protobuf, Msgpack and Octet in that fixed
+// order, returning the first one the line matches. The client's own order
+// and q values within a line do not change which encoding is picked.
+func Negotiate(ctx iris.Context) string {
+	for _, accept := range ctx.Request().Header.Values("Accept") {
+		for _, mime := range []string{Stream, Protobuf, Msgpack, Octet} {
+			if ctx.Negotiation().Accept.MatchRaw(accept, mime) {
+				return mime
+			}
+		}
+	}
+	return JSON
+}
+
+// RawGrid is implemented by any message that reduces to a small fixed
+// header plus a raw f32 grid, which is what the Octet encoding produces.
+type RawGrid interface {
+	GetX() uint32
+	GetY() uint32
+	GetV() []float32
+}
+
+// oneseismic.SliceResponse is the only message this package is ever asked
+// to marshal as Octet today. Assert it implements RawGrid at compile time,
+// so a field rename or type change on the generated message fails the build
+// instead of silently falling back to a JSON body on a request that asked
+// for (and expects) a raw grid.
+var _ RawGrid = (*oneseismic.SliceResponse)(nil)
+
+// Marshal encodes msg per encoding, falling back to JSON for anything
+// unrecognized (including Octet when msg doesn't implement RawGrid). It
+// returns the encoding actually used, since callers need it for
+// Content-Type.
+func Marshal(encoding string, msg proto.Message) (body []byte, usedEncoding string, err error) {
+	switch encoding {
+	case Protobuf:
+		body, err = proto.Marshal(msg)
+		return body, Protobuf, err
+
+	case Msgpack:
+		body, err = msgpack.Marshal(msg)
+		return body, Msgpack, err
+
+	case Octet:
+		if grid, ok := msg.(RawGrid); ok {
+			body, err = marshalRawGrid(grid)
+			return body, Octet, err
+		}
+	}
+
+	m := protojson.MarshalOptions{EmitUnpopulated: true, UseProtoNames: true}
+	body, err = m.Marshal(msg)
+	return body, JSON, err
+}
+
+// marshalRawGrid encodes a small fixed header (dimensions as two
+// little-endian uint32s) followed by the raw, unpadded f32 grid. This
+// avoids the cost of JSON/protobuf marshaling for callers that already know
+// the shape and just want the bytes.
+func marshalRawGrid(grid RawGrid) ([]byte, error) {
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header[0:4], grid.GetX())
+	binary.LittleEndian.PutUint32(header[4:8], grid.GetY())
+
+	v := grid.GetV()
+	body := make([]byte, len(header)+4*len(v))
+	copy(body, header)
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(body[len(header)+4*i:], math.Float32bits(f))
+	}
+	return body, nil
+}
+
+// Write marshals msg per encoding and writes it to ctx with the matching
+// Content-Type, or a 500 on a marshaling failure.
+//
+// Content-Length is deliberately left for iris to set from the bytes
+// actually written: hs.app.Use(iris.Gzip) sits in front of every route, and
+// a Content-Length set here to the uncompressed body size would disagree
+// with what goes out over the wire once gzip re-encodes it.
+func Write(ctx iris.Context, encoding string, msg proto.Message) {
+	body, usedEncoding, err := Marshal(encoding, msg)
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Header("Content-Type", usedEncoding)
+	if _, err := ctx.Write(body); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+	}
+}
+
+// WriteStreamFrame writes one length-prefixed protobuf frame for the Stream
+// encoding and flushes it immediately, so a slow/large response doesn't
+// have to be buffered in full before the first byte reaches the client.
+func WriteStreamFrame(ctx iris.Context, frag proto.Message) error {
+	body, err := proto.Marshal(frag)
+	if err != nil {
+		return err
+	}
+
+	lenbuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(lenbuf, uint32(len(body)))
+	if _, err := ctx.Write(lenbuf); err != nil {
+		return err
+	}
+	if _, err := ctx.Write(body); err != nil {
+		return err
+	}
+	ctx.ResponseWriter().Flush()
+	return nil
+}
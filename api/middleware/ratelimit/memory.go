@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a single token-bucket plus a running count against the monthly
+// byte quota. The byte quota resets one calendar month after the moment it
+// was first touched, which is good enough for a soft quota and avoids
+// pulling in a calendar dependency.
+type bucket struct {
+	tokens     float64
+	lastRefill time.Time
+
+	quotaUsed    int64
+	quotaResetAt time.Time
+}
+
+// memoryStore is the default, single-replica Store: everything lives in a
+// map guarded by a mutex. Fine for one API process; for several replicas
+// sharing counters, use the Redis-backed Store instead.
+type memoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// NewMemoryStore returns a Store backed by an in-process map. It is the
+// default when no shared store is configured.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (s *memoryStore) bucketFor(key string, class RouteClass, limit Limit, now time.Time) *bucket {
+	bkey := string(class) + ":" + key
+	b, ok := s.buckets[bkey]
+	if !ok {
+		// Start full, same as a caller that's simply never been seen
+		// before - otherwise every brand-new key's first request would be
+		// rejected for having "used up" tokens it never had.
+		b = &bucket{tokens: float64(limit.Burst), lastRefill: now, quotaResetAt: now.AddDate(0, 1, 0)}
+		s.buckets[bkey] = b
+	}
+	return b
+}
+
+func (s *memoryStore) Allow(key string, class RouteClass, limit Limit) (bool, State, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	b := s.bucketFor(key, class, limit, now)
+	s.resetQuotaIfDueLocked(b, now)
+
+	elapsed := now.Sub(b.lastRefill).Seconds()
+	b.tokens += elapsed * limit.RatePerSecond
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+	b.lastRefill = now
+
+	quotaExceeded := limit.MonthlyByteQuota > 0 && b.quotaUsed >= limit.MonthlyByteQuota
+	if b.tokens < 1 || quotaExceeded {
+		retryAfter := time.Duration((1 - b.tokens) / limit.RatePerSecond * float64(time.Second))
+		if quotaExceeded {
+			retryAfter = b.quotaResetAt.Sub(now)
+		}
+		return false, State{
+			Limit:      limit.Burst,
+			Remaining:  int64(b.tokens),
+			ResetAfter: retryAfter,
+		}, retryAfter, nil
+	}
+
+	b.tokens--
+
+	return true, State{
+		Limit:      limit.Burst,
+		Remaining:  int64(b.tokens),
+		ResetAfter: time.Duration(float64(limit.Burst-int64(b.tokens)) / limit.RatePerSecond * float64(time.Second)),
+	}, 0, nil
+}
+
+func (s *memoryStore) resetQuotaIfDueLocked(b *bucket, now time.Time) {
+	if now.After(b.quotaResetAt) {
+		b.quotaUsed = 0
+		b.quotaResetAt = now.AddDate(0, 1, 0)
+	}
+}
+
+// Charge adds bytes to key's monthly byte quota once a response's actual
+// size is known.
+func (s *memoryStore) Charge(key string, class RouteClass, limit Limit, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	b := s.bucketFor(key, class, limit, now)
+	s.resetQuotaIfDueLocked(b, now)
+	b.quotaUsed += bytes
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreAllowsBurstThenDenies(t *testing.T) {
+	start := time.Unix(0, 0)
+	s := &memoryStore{buckets: make(map[string]*bucket), now: func() time.Time { return start }}
+	limit := Limit{RatePerSecond: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		allowed, _, _, err := s.Allow("k", ClassSurface, limit)
+		if err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+		if !allowed {
+			t.Fatalf("request %d denied, want allowed within burst", i)
+		}
+	}
+
+	allowed, _, retryAfter, err := s.Allow("k", ClassSurface, limit)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if allowed {
+		t.Fatalf("request beyond burst was allowed")
+	}
+	if retryAfter <= 0 {
+		t.Fatalf("retryAfter = %v, want > 0", retryAfter)
+	}
+}
+
+func TestMemoryStoreRefillsOverTime(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := &memoryStore{buckets: make(map[string]*bucket), now: func() time.Time { return now }}
+	limit := Limit{RatePerSecond: 1, Burst: 1}
+
+	allowed, _, _, _ := s.Allow("k", ClassSurface, limit)
+	if !allowed {
+		t.Fatalf("first request denied")
+	}
+	allowed, _, _, _ = s.Allow("k", ClassSurface, limit)
+	if allowed {
+		t.Fatalf("second immediate request allowed, bucket should be empty")
+	}
+
+	now = now.Add(2 * time.Second)
+	allowed, _, _, _ = s.Allow("k", ClassSurface, limit)
+	if !allowed {
+		t.Fatalf("request after refill window denied")
+	}
+}
+
+func TestMemoryStoreQuotaExceededDenies(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := &memoryStore{buckets: make(map[string]*bucket), now: func() time.Time { return now }}
+	limit := Limit{RatePerSecond: 100, Burst: 100, MonthlyByteQuota: 10}
+
+	s.Charge("k", ClassSurface, limit, 10)
+
+	allowed, _, retryAfter, err := s.Allow("k", ClassSurface, limit)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if allowed {
+		t.Fatalf("request allowed despite exhausted byte quota")
+	}
+	if retryAfter <= 29*24*time.Hour {
+		t.Fatalf("retryAfter = %v, want roughly a month out", retryAfter)
+	}
+}
+
+func TestMemoryStoreQuotaResetsAfterPeriod(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := &memoryStore{buckets: make(map[string]*bucket), now: func() time.Time { return now }}
+	limit := Limit{RatePerSecond: 100, Burst: 100, MonthlyByteQuota: 10}
+
+	s.Charge("k", ClassSurface, limit, 10)
+
+	allowed, _, _, _ := s.Allow("k", ClassSurface, limit)
+	if allowed {
+		t.Fatalf("request allowed before quota period rolled over")
+	}
+
+	now = now.AddDate(0, 1, 1)
+	allowed, _, _, err := s.Allow("k", ClassSurface, limit)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if !allowed {
+		t.Fatalf("request denied after quota period rolled over, want a fresh quota")
+	}
+}
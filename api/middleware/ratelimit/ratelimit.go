@@ -0,0 +1,178 @@
+// Package ratelimit implements token-bucket rate limiting and monthly byte
+// quotas for the HTTP API, keyed by the JWT subject/audience that
+// claimsmiddleware already validated. It is mounted as its own middleware,
+// sibling to claimsmiddleware, rather than folded into it, since the two
+// have independent failure modes (401 vs 429) and configuration.
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	irisCtx "github.com/kataras/iris/v12/context"
+)
+
+// RouteClass buckets endpoints that should share a limit. Stitch requests do
+// real CPU/IO work fetching and merging fragments, so they get their own,
+// stricter, class.
+type RouteClass string
+
+const (
+	ClassSurface  RouteClass = "surface"
+	ClassManifest RouteClass = "manifest"
+	ClassStitch   RouteClass = "stitch"
+	classDefault  RouteClass = "default"
+)
+
+// ClassifyPath maps a request path to the RouteClass whose limits should
+// apply to it.
+func ClassifyPath(path string) RouteClass {
+	switch {
+	case strings.HasPrefix(path, "/surface"):
+		return ClassSurface
+	case strings.HasPrefix(path, "/manifest"):
+		return ClassManifest
+	case strings.HasPrefix(path, "/stitch"):
+		return ClassStitch
+	default:
+		return classDefault
+	}
+}
+
+// Limit is the token-bucket rate and monthly byte quota for a RouteClass.
+// MonthlyByteQuota of 0 means no byte quota is enforced for that class.
+type Limit struct {
+	RatePerSecond    float64
+	Burst            int64
+	MonthlyByteQuota int64
+}
+
+// Config maps route classes to the limit that should apply to them. Classes
+// with no entry fall back to DefaultLimit.
+type Config struct {
+	Limits       map[RouteClass]Limit
+	DefaultLimit Limit
+}
+
+func (c Config) limitFor(class RouteClass) Limit {
+	if l, ok := c.Limits[class]; ok {
+		return l
+	}
+	return c.DefaultLimit
+}
+
+// State is the bucket state returned alongside an Allow decision, used to
+// populate the X-RateLimit-* response headers.
+type State struct {
+	Limit      int64
+	Remaining  int64
+	ResetAfter time.Duration
+}
+
+// Store is the pluggable backing for bucket state. The default is an
+// in-memory store; Redis is provided for when multiple API replicas need to
+// share counters.
+type Store interface {
+	// Allow consumes one token from the bucket identified by key+class,
+	// rejecting the request outright if the monthly byte quota was already
+	// exceeded by a prior response. It reports whether the request is
+	// allowed, the resulting bucket state, and - when denied - how long the
+	// caller should wait before retrying.
+	Allow(key string, class RouteClass, limit Limit) (allowed bool, state State, retryAfter time.Duration, err error)
+
+	// Charge adds bytes to key's monthly byte quota once a response's
+	// actual size is known, which isn't until after the handler runs.
+	Charge(key string, class RouteClass, limit Limit, bytes int64)
+}
+
+// Middleware enforces Config against requests using Store, keyed by the
+// claims subject combined with the token's audience when it has one
+// (falling back to the remote address when there is no subject, so an
+// unauthenticated request still gets a sane default bucket).
+type Middleware struct {
+	cfg   Config
+	store Store
+}
+
+// New builds a Middleware backed by store. Pass NewMemoryStore() for a
+// single-replica deployment, or a Redis-backed Store when running several
+// API replicas that must share counters.
+func New(cfg Config, store Store) *Middleware {
+	return &Middleware{cfg: cfg, store: store}
+}
+
+// subjectOf keys a bucket off the caller's JWT subject and audience, the
+// way claimsmiddleware and the rest of this server's middleware stack
+// already identify a request - see signedurl/middleware.go, which sets the
+// same "jwt" context value, and sliceController.get, which reads it back.
+// A request with no JWT (shouldn't normally reach this middleware, since it
+// runs after WithOAuth2) falls back to the remote address so it still gets
+// a bucket rather than panicking.
+func subjectOf(ctx irisCtx.Context) string {
+	sub, _ := ctx.Values().Get("jwt").(string)
+	if sub == "" {
+		return ctx.RemoteAddr()
+	}
+
+	if aud := audienceOf(ctx); aud != "" {
+		return sub + "@" + aud
+	}
+	return sub
+}
+
+// audienceOf reads the aud claim off whichever of the RS256/HS256 tokens
+// WithOAuth2 validated the request with.
+func audienceOf(ctx irisCtx.Context) string {
+	token, _ := ctx.Values().Get("user-jwt").(*jwt.Token)
+	if token == nil {
+		token, _ = ctx.Values().Get("service-jwt").(*jwt.Token)
+	}
+	if token == nil {
+		return ""
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	aud, _ := claims["aud"].(string)
+	return aud
+}
+
+// Validate is the iris handler: it classifies the route, looks up the
+// caller's bucket, and either lets the request through (setting
+// X-RateLimit-* headers) or rejects it with 429 and Retry-After.
+func (m *Middleware) Validate(ctx irisCtx.Context) {
+	class := ClassifyPath(ctx.Path())
+	limit := m.cfg.limitFor(class)
+	key := subjectOf(ctx)
+
+	allowed, state, retryAfter, err := m.store.Allow(key, class, limit)
+	if err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Header("X-RateLimit-Limit", strconv.FormatInt(state.Limit, 10))
+	ctx.Header("X-RateLimit-Remaining", strconv.FormatInt(state.Remaining, 10))
+	ctx.Header("X-RateLimit-Reset", strconv.Itoa(int(state.ResetAfter.Seconds())))
+
+	if !allowed {
+		ctx.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+		ctx.StatusCode(http.StatusTooManyRequests)
+		ctx.StopExecution()
+		return
+	}
+
+	ctx.Next()
+
+	// Only now, after the handler has written the response, do we know how
+	// many bytes it actually cost - charge the monthly quota by that, not
+	// a fixed per-request guess.
+	if limit.MonthlyByteQuota > 0 {
+		m.store.Charge(key, class, limit, int64(ctx.ResponseWriter().Written()))
+	}
+}
@@ -0,0 +1,157 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// bucketTTL bounds how long an idle bucket's keys live in Redis. A bucket
+// that hasn't been touched in this long is safe to forget entirely - the
+// caller just gets a fresh, full bucket on its next request - which keeps
+// Redis memory from growing without bound as distinct keys (e.g. one per
+// remote address for unauthenticated callers) come and go.
+const bucketTTL = 30 * 24 * time.Hour
+
+// quotaPeriod is how long a monthly byte quota stays in effect once first
+// touched, mirroring the memoryStore's AddDate(0, 1, 0) (see memory.go) -
+// Lua has no calendar arithmetic, so this is the fixed-duration equivalent.
+const quotaPeriod = 30 * 24 * time.Hour
+
+// redisStore implements Store on top of Redis so multiple API replicas can
+// share bucket state. Token refill and quota bookkeeping are done with Lua
+// scripts so a read-modify-write round trip never races between replicas.
+type redisStore struct {
+	client *redis.Client
+	now    func() time.Time
+}
+
+// NewRedisStore returns a Store backed by the given Redis client. Use this
+// instead of NewMemoryStore when running more than one API replica behind a
+// load balancer.
+func NewRedisStore(client *redis.Client) Store {
+	return &redisStore{client: client, now: time.Now}
+}
+
+// allowScript atomically refills the bucket, checks whether the monthly
+// quota was already exceeded by a previous Charge and, if the request is
+// allowed, deducts a token. It returns {allowed, tokens_remaining,
+// quota_reset_at, quota_exceeded} - quota_reset_at is the unix time the
+// current quota period ends, so a quota-exceeded denial can tell the caller
+// when the quota actually clears instead of when the next token refills.
+var allowScript = redis.NewScript(`
+local tokens_key = KEYS[1]
+local quota_key = KEYS[2]
+local refill_key = KEYS[3]
+local quota_reset_key = KEYS[4]
+
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local quota = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+local quota_period = tonumber(ARGV[6])
+
+local last = tonumber(redis.call("GET", refill_key) or now)
+local tokens = tonumber(redis.call("GET", tokens_key) or burst)
+tokens = math.min(burst, tokens + (now - last) * rate)
+
+local quota_reset_at = tonumber(redis.call("GET", quota_reset_key))
+local used = tonumber(redis.call("GET", quota_key) or 0)
+
+if not quota_reset_at then
+	quota_reset_at = now + quota_period
+	redis.call("SET", quota_reset_key, quota_reset_at, "EX", ttl)
+elseif now >= quota_reset_at then
+	-- The period has rolled over: forget bytes used in the previous
+	-- period and open a fresh one, the same as
+	-- memoryStore.resetQuotaIfDueLocked (memory.go). Without this, used
+	-- only ever goes away via quota_key's TTL, which Charge keeps
+	-- sliding forward on every write and so never actually expires under
+	-- continuous traffic - the one case a quota exists to catch.
+	used = 0
+	quota_reset_at = now + quota_period
+	redis.call("SET", quota_key, 0, "EX", ttl)
+	redis.call("SET", quota_reset_key, quota_reset_at, "EX", ttl)
+end
+
+local quota_exceeded = quota > 0 and (used >= quota)
+
+if tokens < 1 or quota_exceeded then
+	redis.call("SET", tokens_key, tokens, "EX", ttl)
+	redis.call("SET", refill_key, now, "EX", ttl)
+	return {0, tokens, quota_reset_at, quota_exceeded and 1 or 0}
+end
+
+tokens = tokens - 1
+
+redis.call("SET", tokens_key, tokens, "EX", ttl)
+redis.call("SET", refill_key, now, "EX", ttl)
+
+return {1, tokens, quota_reset_at, 0}
+`)
+
+// chargeScript adds bytes to the monthly byte quota once a response's
+// actual size is known.
+var chargeScript = redis.NewScript(`
+local quota_key = KEYS[1]
+local bytes = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+
+return redis.call("INCRBY", quota_key, bytes) and redis.call("EXPIRE", quota_key, ttl)
+`)
+
+func (s *redisStore) keys(class RouteClass, key string) (tokens, quota, refill, quotaReset string) {
+	prefix := "oneseismic:ratelimit:" + string(class) + ":" + key
+	return prefix + ":tokens", prefix + ":quota", prefix + ":refill", prefix + ":quota_reset"
+}
+
+func (s *redisStore) Allow(key string, class RouteClass, limit Limit) (bool, State, time.Duration, error) {
+	ctx := context.Background()
+	now := s.now()
+	tokensKey, quotaKey, refillKey, quotaResetKey := s.keys(class, key)
+
+	res, err := allowScript.Run(ctx, s.client, []string{tokensKey, quotaKey, refillKey, quotaResetKey},
+		limit.RatePerSecond,
+		limit.Burst,
+		limit.MonthlyByteQuota,
+		float64(now.Unix()),
+		int(bucketTTL.Seconds()),
+		int(quotaPeriod.Seconds()),
+	).Result()
+	if err != nil {
+		return false, State{}, 0, err
+	}
+
+	row := res.([]interface{})
+	allowed := row[0].(int64) == 1
+	tokensLeft := row[1].(int64)
+	quotaResetAt := time.Unix(row[2].(int64), 0)
+	quotaExceeded := row[3].(int64) == 1
+
+	state := State{
+		Limit:      limit.Burst,
+		Remaining:  tokensLeft,
+		ResetAfter: time.Duration(float64(limit.Burst-tokensLeft) / limit.RatePerSecond * float64(time.Second)),
+	}
+
+	if !allowed {
+		// A request can be denied either because the bucket is empty (retry
+		// once it refills) or because the byte quota is already exhausted
+		// (retry only once the quota period resets, which can be much
+		// further out than the next token) - prefer whichever is further
+		// away so the client isn't told to retry before it actually can.
+		retryAfter := state.ResetAfter
+		if quotaRetry := quotaResetAt.Sub(now); quotaExceeded && quotaRetry > retryAfter {
+			retryAfter = quotaRetry
+		}
+		return false, state, retryAfter, nil
+	}
+	return true, state, 0, nil
+}
+
+func (s *redisStore) Charge(key string, class RouteClass, limit Limit, bytes int64) {
+	_, quotaKey, _, _ := s.keys(class, key)
+	chargeScript.Run(context.Background(), s.client, []string{quotaKey}, bytes, int(bucketTTL.Seconds()))
+}
@@ -0,0 +1,118 @@
+package signedurl
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func testKeyRing(t *testing.T) *KeyRing {
+	t.Helper()
+	kr, err := NewKeyRing(Config{
+		Keys:       map[string][]byte{"k1": []byte("secret-one"), "k2": []byte("secret-two")},
+		CurrentKid: "k1",
+	})
+	if err != nil {
+		t.Fatalf("NewKeyRing: %v", err)
+	}
+	return kr
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	kr := testKeyRing(t)
+
+	signed, expires, err := kr.Sign("/slice/abc/0/1", url.Values{}, "user-1", time.Minute)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if expires.Before(time.Now()) {
+		t.Fatalf("expires %v is already in the past", expires)
+	}
+
+	sub, ok := kr.Verify("/slice/abc/0/1", signed)
+	if !ok {
+		t.Fatalf("Verify rejected a freshly signed URL")
+	}
+	if sub != "user-1" {
+		t.Fatalf("sub = %q, want %q", sub, "user-1")
+	}
+}
+
+func TestVerifyRejectsTamperedPath(t *testing.T) {
+	kr := testKeyRing(t)
+
+	signed, _, err := kr.Sign("/slice/abc/0/1", url.Values{}, "user-1", time.Minute)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	if _, ok := kr.Verify("/slice/abc/0/2", signed); ok {
+		t.Fatalf("Verify accepted a URL signed for a different path")
+	}
+}
+
+func TestVerifyRejectsTamperedQuery(t *testing.T) {
+	kr := testKeyRing(t)
+
+	signed, _, err := kr.Sign("/slice/abc/0/1", url.Values{}, "user-1", time.Minute)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	signed.Set("sub", "user-2")
+	if _, ok := kr.Verify("/slice/abc/0/1", signed); ok {
+		t.Fatalf("Verify accepted a URL with a tampered sub")
+	}
+}
+
+func TestVerifyRejectsExpiredURL(t *testing.T) {
+	kr := testKeyRing(t)
+
+	signed, _, err := kr.Sign("/slice/abc/0/1", url.Values{}, "user-1", -time.Minute)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	if _, ok := kr.Verify("/slice/abc/0/1", signed); ok {
+		t.Fatalf("Verify accepted an already-expired URL")
+	}
+}
+
+func TestVerifyRejectsUnknownKid(t *testing.T) {
+	kr := testKeyRing(t)
+
+	signed, _, err := kr.Sign("/slice/abc/0/1", url.Values{}, "user-1", time.Minute)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	signed.Set("kid", "k3")
+	if _, ok := kr.Verify("/slice/abc/0/1", signed); ok {
+		t.Fatalf("Verify accepted a kid that isn't in the key ring")
+	}
+}
+
+func TestVerifyRejectsMissingParams(t *testing.T) {
+	kr := testKeyRing(t)
+
+	if _, ok := kr.Verify("/slice/abc/0/1", url.Values{}); ok {
+		t.Fatalf("Verify accepted a URL with no signature params at all")
+	}
+}
+
+func TestNewKeyRingRejectsUnknownCurrentKid(t *testing.T) {
+	_, err := NewKeyRing(Config{
+		Keys:       map[string][]byte{"k1": []byte("secret")},
+		CurrentKid: "missing",
+	})
+	if err == nil {
+		t.Fatalf("NewKeyRing accepted a CurrentKid absent from Keys")
+	}
+}
+
+func TestNewKeyRingRejectsEmptyKeys(t *testing.T) {
+	_, err := NewKeyRing(Config{})
+	if err == nil {
+		t.Fatalf("NewKeyRing accepted an empty key ring")
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/equinor/seismic-cloud/api/service/manifesttrust"
+	"github.com/kataras/golog"
+	"github.com/kataras/iris/v12"
+)
+
+// manifestSignatureHeader carries a manifest's detached Ed25519 signature,
+// base64 encoded, on upload and is handed back unchanged on download.
+const manifestSignatureHeader = "X-Oneseismic-Signature"
+
+// manifestModel is the concrete read/write path backing the manifest
+// endpoints. Unlike store.ManifestStore, it carries a manifest's signature
+// alongside its bytes, since manifestController needs both to verify a
+// manifest against manifesttrust on read.
+type manifestModel interface {
+	downloadManifest(id string) (manifest []byte, signature []byte, err error)
+	uploadManifest(id string, manifest []byte, signature []byte) error
+}
+
+type manifestController struct {
+	model manifestModel
+	trust *manifesttrust.TrustRoots
+}
+
+func newManifestController(model manifestModel, trust *manifesttrust.TrustRoots) *manifestController {
+	return &manifestController{model: model, trust: trust}
+}
+
+// download serves a manifest's bytes, refusing it with 409 Conflict if
+// trust roots are configured and its signature doesn't verify - a
+// substituted manifest is caught here rather than handed to the caller (or,
+// worse, to the stitcher) as if it were genuine.
+func (mc *manifestController) download(ctx iris.Context) {
+	id := ctx.Params().GetString("manifestID")
+
+	manifest, signature, err := mc.model.downloadManifest(id)
+	if err != nil {
+		golog.Error(err)
+		ctx.StatusCode(http.StatusNotFound)
+		return
+	}
+
+	if mc.trust != nil {
+		if err := mc.trust.Check(id, manifest, signature); err != nil {
+			golog.Error(err)
+			ctx.StatusCode(http.StatusConflict)
+			return
+		}
+	}
+
+	if len(signature) > 0 {
+		ctx.Header(manifestSignatureHeader, base64.StdEncoding.EncodeToString(signature))
+	}
+	if _, err := ctx.Write(manifest); err != nil {
+		ctx.StatusCode(http.StatusInternalServerError)
+	}
+}
+
+// upload stores a manifest alongside whatever signature arrived with it in
+// the X-Oneseismic-Signature header, so a later download has something to
+// verify. It doesn't itself reject an unsigned or mismatched manifest -
+// that's download's job - so a trust rollout can still be staged by
+// uploading ahead of turning ModeEnforce on.
+func (mc *manifestController) upload(ctx iris.Context) {
+	id := ctx.Params().GetString("manifestID")
+
+	manifest, err := ioutil.ReadAll(ctx.Request().Body)
+	if err != nil {
+		ctx.StatusCode(http.StatusBadRequest)
+		return
+	}
+
+	var signature []byte
+	if encoded := ctx.GetHeader(manifestSignatureHeader); len(encoded) > 0 {
+		signature, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			ctx.StatusCode(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := mc.model.uploadManifest(id, manifest, signature); err != nil {
+		golog.Error(err)
+		ctx.StatusCode(http.StatusInternalServerError)
+		return
+	}
+	ctx.StatusCode(http.StatusOK)
+}
@@ -1,13 +1,17 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/equinor/oneseismic/api/oneseismic"
+	"github.com/equinor/seismic-cloud/api/httpencoding"
+	"github.com/equinor/seismic-cloud/api/service/fragmentcache"
 	"github.com/google/uuid"
 	"github.com/kataras/golog"
 	"github.com/kataras/iris/v12"
-	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 type failure struct {
@@ -65,10 +69,101 @@ type sliceModel interface {
 		dim int32,
 		lineno int32,
 		requestid string) (*oneseismic.SliceResponse, error)
+
+	// fetchSliceStream is the streaming counterpart of fetchSlice: instead
+	// of assembling the full SliceResponse in memory, fragments are handed
+	// back on frags as they arrive so the controller can flush them to the
+	// client one at a time.
+	//
+	// The implementation must close frags first, once every fragment has
+	// been sent (whether the fetch ultimately succeeded or failed), and
+	// only then send-or-close errs. errs must be buffered with capacity (at
+	// least) 1, so that final send never blocks. The consumer drains frags
+	// to completion before it ever looks at errs, so sending the error
+	// first would deadlock it: the consumer would still be parked in `range
+	// frags`, never reaching the `<-errs` that would unblock the producer.
+	fetchSliceStream(
+		auth string,
+		guid string,
+		dim int32,
+		lineno int32,
+		requestid string) (frags <-chan *oneseismic.SliceFragment, errs <-chan error)
 }
 
 type sliceController struct {
 	slicer sliceModel
+	cache  fragmentcache.Cache
+}
+
+func newSliceController(slicer sliceModel, cache fragmentcache.Cache) *sliceController {
+	return &sliceController{slicer: slicer, cache: cache}
+}
+
+// sliceCacheTTL bounds how long a cached slice is served before the next
+// request for it goes back to fetchSlice.
+const sliceCacheTTL = 5 * time.Minute
+
+// fetchSlice looks up (guid, dim, lineno) in the fragment cache before
+// falling back to sc.slicer.fetchSlice, so repeated requests for the same
+// slice don't all reach the blob store. Concurrent misses for the same key
+// are coalesced into a single upstream fetch by the cache itself.
+func (sc *sliceController) fetchSlice(auth, guid string, dim, lineno int32, requestid string) (*oneseismic.SliceResponse, error) {
+	if sc.cache == nil {
+		return sc.slicer.fetchSlice(auth, guid, dim, lineno, requestid)
+	}
+
+	key := fmt.Sprintf("slice/%s/%d/%d", guid, dim, lineno)
+	body, err := sc.cache.Fetch(key, sliceCacheTTL, func() ([]byte, error) {
+		slice, err := sc.slicer.fetchSlice(auth, guid, dim, lineno, requestid)
+		if err != nil {
+			return nil, err
+		}
+		return proto.Marshal(slice)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slice := &oneseismic.SliceResponse{}
+	if err := proto.Unmarshal(body, slice); err != nil {
+		return nil, err
+	}
+	return slice, nil
+}
+
+func (sc *sliceController) writeSlice(ctx iris.Context, encoding string, slice *oneseismic.SliceResponse) {
+	httpencoding.Write(ctx, encoding, slice)
+}
+
+// writeSliceStream writes length-prefixed protobuf frames as they arrive on
+// frags, flushing after each one so a slow/large slice doesn't have to be
+// buffered in full before the first byte reaches the client.
+func (sc *sliceController) writeSliceStream(
+	ctx iris.Context,
+	frags <-chan *oneseismic.SliceFragment,
+	errs <-chan error,
+) {
+	ctx.Header("Content-Type", httpencoding.Stream)
+	ctx.Header("Transfer-Encoding", "chunked")
+
+	for frag := range frags {
+		if err := httpencoding.WriteStreamFrame(ctx, frag); err != nil {
+			golog.Error(err)
+			// Keep draining so the producer can finish and close frags.
+			for range frags {
+			}
+			return
+		}
+	}
+
+	if err := <-errs; err != nil {
+		switch e := err.(type) {
+		case *failure:
+			golog.Errorf("stream interrupted; requestid = %s: %v", ctx.Values().Get("requestid"), e)
+		default:
+			golog.Error(e)
+		}
+	}
 }
 
 func (sc *sliceController) get(ctx iris.Context) {
@@ -94,8 +189,17 @@ func (sc *sliceController) get(ctx iris.Context) {
 		return
 	}
 	requestid := uuid.New().String()
+	ctx.Values().Set("requestid", requestid)
 	auth := ctx.GetHeader("Authorization")
-	slice, err := sc.slicer.fetchSlice(auth, guid, dim, lineno, requestid)
+
+	encoding := httpencoding.Negotiate(ctx)
+	if encoding == httpencoding.Stream {
+		frags, errs := sc.slicer.fetchSliceStream(auth, guid, dim, lineno, requestid)
+		sc.writeSliceStream(ctx, frags, errs)
+		return
+	}
+
+	slice, err := sc.fetchSlice(auth, guid, dim, lineno, requestid)
 	if err != nil {
 		switch e := err.(type) {
 		case *failure:
@@ -108,18 +212,5 @@ func (sc *sliceController) get(ctx iris.Context) {
 		return
 	}
 
-	ctx.Header("Content-Type", "application/json")
-	m := protojson.MarshalOptions{EmitUnpopulated: true, UseProtoNames: true}
-	js, err := m.Marshal(slice)
-	if err != nil {
-		ctx.StatusCode(http.StatusInternalServerError)
-		return
-	}
-	_, err = ctx.Write(js)
-	if err != nil {
-		ctx.StatusCode(http.StatusInternalServerError)
-		return
-	}
-
-	return
+	sc.writeSlice(ctx, encoding, slice)
 }
@@ -0,0 +1,22 @@
+// Package fragmentcache sits between sliceModel/stitch and the blob store so
+// repeated requests for the same fragment don't round-trip to Azure Blob.
+// Besides plain get/put, it coalesces concurrent misses for the same key
+// (a thundering herd of requests for a fragment that's mid-fetch results in
+// exactly one upstream call) the way a registry dependency proxy would.
+package fragmentcache
+
+import "time"
+
+// Cache is the interface sliceModel and the stitch paths fetch fragments
+// through. Key is whatever the caller uses to identify a fragment - for
+// slices that's typically "guid/dim/lineno", for stitch a fragment id.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Put(key string, value []byte, ttl time.Duration)
+
+	// Fetch returns the cached bytes for key, or - on a miss - calls fetch
+	// to populate the cache. Concurrent Fetch calls for the same key while
+	// a fetch is in flight share its result rather than each calling fetch
+	// themselves (request coalescing).
+	Fetch(key string, ttl time.Duration, fetch func() ([]byte, error)) ([]byte, error)
+}
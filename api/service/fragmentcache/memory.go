@@ -0,0 +1,127 @@
+package fragmentcache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// lruCache is an in-memory Cache bounded by total byte size rather than
+// entry count, since fragments vary a lot in size. Eviction is plain LRU;
+// an expired entry is dropped lazily, when a Get or Fetch for its key finds
+// it expired.
+type lruCache struct {
+	mu         sync.Mutex
+	byteBudget int64
+	usedBytes  int64
+	ll         *list.List
+	index      map[string]*list.Element
+
+	group singleflight.Group
+	now   func() time.Time
+}
+
+// NewLRU returns an in-memory Cache that evicts the least-recently-used
+// entry once the cached bytes exceed byteBudget.
+func NewLRU(byteBudget int64) Cache {
+	return &lruCache{
+		byteBudget: byteBudget,
+		ll:         list.New(),
+		index:      make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+func (c *lruCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getLocked(key)
+}
+
+func (c *lruCache) getLocked(key string) ([]byte, bool) {
+	el, ok := c.index[key]
+	if !ok {
+		cacheMisses.Inc()
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if c.now().After(e.expires) {
+		c.removeLocked(el)
+		cacheMisses.Inc()
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	cacheHits.Inc()
+	return e.value, true
+}
+
+func (c *lruCache) Put(key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.putLocked(key, value, ttl)
+}
+
+func (c *lruCache) putLocked(key string, value []byte, ttl time.Duration) {
+	if el, ok := c.index[key]; ok {
+		c.removeLocked(el)
+	}
+
+	el := c.ll.PushFront(&entry{key: key, value: value, expires: c.now().Add(ttl)})
+	c.index[key] = el
+	c.usedBytes += int64(len(value))
+
+	for c.usedBytes > c.byteBudget {
+		back := c.ll.Back()
+		if back == nil {
+			break
+		}
+		c.removeLocked(back)
+	}
+}
+
+func (c *lruCache) removeLocked(el *list.Element) {
+	e := el.Value.(*entry)
+	delete(c.index, e.key)
+	c.ll.Remove(el)
+	c.usedBytes -= int64(len(e.value))
+}
+
+func (c *lruCache) Fetch(key string, ttl time.Duration, fetch func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if value, ok := c.getLocked(key); ok {
+		c.mu.Unlock()
+		return value, nil
+	}
+	c.mu.Unlock()
+
+	leader := false
+	v, err, shared := c.group.Do(key, func() (interface{}, error) {
+		leader = true
+		value, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.putLocked(key, value, ttl)
+		c.mu.Unlock()
+		return value, nil
+	})
+	if shared && !leader {
+		cacheCoalesced.Inc()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
@@ -0,0 +1,161 @@
+package fragmentcache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLRUGetPutRoundTrip(t *testing.T) {
+	c := NewLRU(1024).(*lruCache)
+
+	c.Put("a", []byte("hello"), time.Minute)
+
+	value, ok := c.Get("a")
+	if !ok {
+		t.Fatalf("Get missed a key that was just Put")
+	}
+	if string(value) != "hello" {
+		t.Fatalf("value = %q, want %q", value, "hello")
+	}
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU(10).(*lruCache)
+
+	c.Put("a", []byte("12345"), time.Minute)
+	c.Put("b", []byte("12345"), time.Minute)
+
+	// Touch "a" so "b" becomes the least-recently-used entry.
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("Get(a) missed right after Put")
+	}
+
+	// Pushes usedBytes over the 10 byte budget; "b" should be evicted, not "a".
+	c.Put("c", []byte("12345"), time.Minute)
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatalf("Get(b) hit, want it evicted as least-recently-used")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("Get(a) missed, want it retained as recently-used")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Fatalf("Get(c) missed right after Put")
+	}
+}
+
+func TestLRUExpiresEntriesPastTTL(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := &lruCache{
+		byteBudget: 1024,
+		ll:         list.New(),
+		index:      make(map[string]*list.Element),
+		now:        func() time.Time { return now },
+	}
+
+	c.Put("a", []byte("hello"), time.Minute)
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Fatalf("Get(a) hit after its TTL elapsed")
+	}
+}
+
+func TestLRUFetchPopulatesOnMiss(t *testing.T) {
+	c := NewLRU(1024).(*lruCache)
+	calls := 0
+
+	value, err := c.Fetch("a", time.Minute, func() ([]byte, error) {
+		calls++
+		return []byte("fetched"), nil
+	})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if string(value) != "fetched" {
+		t.Fatalf("value = %q, want %q", value, "fetched")
+	}
+	if calls != 1 {
+		t.Fatalf("fetch called %d times, want 1", calls)
+	}
+
+	value, err = c.Fetch("a", time.Minute, func() ([]byte, error) {
+		calls++
+		return []byte("should not be called again"), nil
+	})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if string(value) != "fetched" {
+		t.Fatalf("value = %q, want the cached value on a second Fetch", value)
+	}
+	if calls != 1 {
+		t.Fatalf("fetch called %d times on a cache hit, want 1", calls)
+	}
+}
+
+func TestLRUFetchReturnsFetchError(t *testing.T) {
+	c := NewLRU(1024).(*lruCache)
+	wantErr := errors.New("upstream failed")
+
+	_, err := c.Fetch("a", time.Minute, func() ([]byte, error) {
+		return nil, wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("err = %v, want %v", err, wantErr)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatalf("a failed fetch was cached")
+	}
+}
+
+func TestLRUFetchCoalescesConcurrentMisses(t *testing.T) {
+	c := NewLRU(1024).(*lruCache)
+
+	var calls int
+	var mu sync.Mutex
+	release := make(chan struct{})
+
+	fetch := func() ([]byte, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		return []byte("fetched"), nil
+	}
+
+	const n = 5
+	var wg sync.WaitGroup
+	results := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value, err := c.Fetch("a", time.Minute, fetch)
+			if err != nil {
+				t.Errorf("Fetch: %v", err)
+				return
+			}
+			results[i] = value
+		}(i)
+	}
+
+	// Give every goroutine a chance to reach group.Do before unblocking fetch.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Fatalf("fetch called %d times for %d concurrent misses, want 1", calls, n)
+	}
+	for i, value := range results {
+		if string(value) != "fetched" {
+			t.Fatalf("result %d = %q, want %q", i, value, "fetched")
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package fragmentcache
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// These are registered against the default registry so they show up on the
+// same /metrics endpoint the profiling middleware (see
+// server.WithProfiling) already exposes.
+var (
+	cacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "oneseismic_fragmentcache_hits_total",
+		Help: "Number of fragment cache lookups that found a cached value.",
+	})
+	cacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "oneseismic_fragmentcache_misses_total",
+		Help: "Number of fragment cache lookups that found nothing cached.",
+	})
+	cacheCoalesced = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "oneseismic_fragmentcache_coalesced_total",
+		Help: "Number of Fetch calls that shared an in-flight upstream fetch instead of starting their own.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(cacheHits, cacheMisses, cacheCoalesced)
+}
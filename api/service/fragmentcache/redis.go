@@ -0,0 +1,62 @@
+package fragmentcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+// redisCache is a Cache backed by Redis so a fragment fetched by one API
+// replica is available to the others. Coalescing is still only local to
+// this process - singleflight.Group can't coordinate across replicas - but
+// that already removes the common case of a single replica fanning the same
+// fragment request out to blob storage many times.
+type redisCache struct {
+	client *redis.Client
+	group  singleflight.Group
+}
+
+// NewRedis returns a Cache backed by the given Redis client.
+func NewRedis(client *redis.Client) Cache {
+	return &redisCache{client: client}
+}
+
+func (c *redisCache) Get(key string) ([]byte, bool) {
+	value, err := c.client.Get(context.Background(), key).Bytes()
+	if err != nil {
+		cacheMisses.Inc()
+		return nil, false
+	}
+	cacheHits.Inc()
+	return value, true
+}
+
+func (c *redisCache) Put(key string, value []byte, ttl time.Duration) {
+	c.client.Set(context.Background(), key, value, ttl)
+}
+
+func (c *redisCache) Fetch(key string, ttl time.Duration, fetch func() ([]byte, error)) ([]byte, error) {
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+
+	leader := false
+	v, err, shared := c.group.Do(key, func() (interface{}, error) {
+		leader = true
+		value, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+		c.Put(key, value, ttl)
+		return value, nil
+	})
+	if shared && !leader {
+		cacheCoalesced.Inc()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
@@ -0,0 +1,82 @@
+// Package manifesttrust verifies manifests against a configured set of
+// Ed25519 trust roots, so write access to blob storage alone is no longer
+// enough to make stitch trust a substituted manifest. A manifest is trusted
+// when its detached signature verifies against at least one trust root key.
+package manifesttrust
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/kataras/golog"
+)
+
+// Mode controls what Check does when a manifest's signature doesn't verify.
+type Mode int
+
+const (
+	// ModeEnforce refuses the manifest: Check returns a *VerificationError.
+	ModeEnforce Mode = iota
+	// ModeWarn logs the failure and still returns nil, for staged rollout
+	// ahead of turning enforcement on.
+	ModeWarn
+)
+
+// VerificationError is returned by Check, in ModeEnforce, when a manifest's
+// signature doesn't verify against any trusted key. Callers map this to
+// 409 Conflict.
+type VerificationError struct {
+	ID string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("manifest %q failed signature verification against trust root", e.ID)
+}
+
+// TrustRoots is the set of Ed25519 public keys manifests may be signed
+// with, loaded once via server.WithManifestTrustRoots.
+type TrustRoots struct {
+	keys []ed25519.PublicKey
+	mode Mode
+}
+
+// New builds a TrustRoots from keys, operating in mode.
+func New(keys []ed25519.PublicKey, mode Mode) *TrustRoots {
+	return &TrustRoots{keys: keys, mode: mode}
+}
+
+// Sign produces a detached Ed25519 signature over manifest's canonical
+// bytes, to be stored alongside it and sent back as the
+// X-Oneseismic-Signature header value on upload.
+func Sign(key ed25519.PrivateKey, manifest []byte) []byte {
+	return ed25519.Sign(key, manifest)
+}
+
+// Check verifies sig as a detached signature over manifest against every
+// trust root, succeeding if any one of them matches. On failure in
+// ModeEnforce it returns a *VerificationError the caller should turn into a
+// 409 Conflict; in ModeWarn it logs instead and returns nil.
+func (t *TrustRoots) Check(id string, manifest, sig []byte) error {
+	for _, key := range t.keys {
+		if len(sig) == ed25519.SignatureSize && ed25519.Verify(key, manifest, sig) {
+			verifySuccess.Inc()
+			return nil
+		}
+	}
+
+	verifyFailure.Inc()
+	if t.mode == ModeWarn {
+		golog.Warnf("manifest %q failed signature verification against trust root (warn-only mode)", id)
+		return nil
+	}
+	return &VerificationError{ID: id}
+}
+
+// ParsePublicKey parses a raw 32-byte Ed25519 public key, the form trust
+// roots are configured in.
+func ParsePublicKey(raw []byte) (ed25519.PublicKey, error) {
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("manifesttrust: public key must be %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
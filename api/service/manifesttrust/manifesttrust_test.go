@@ -0,0 +1,97 @@
+package manifesttrust
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func generateKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey: %v", err)
+	}
+	return pub, priv
+}
+
+func TestCheckAcceptsValidSignature(t *testing.T) {
+	pub, priv := generateKey(t)
+	manifest := []byte(`{"guid":"abc"}`)
+	sig := Sign(priv, manifest)
+
+	tr := New([]ed25519.PublicKey{pub}, ModeEnforce)
+	if err := tr.Check("abc", manifest, sig); err != nil {
+		t.Fatalf("Check: %v, want nil for a validly signed manifest", err)
+	}
+}
+
+func TestCheckAcceptsAnyMatchingTrustRoot(t *testing.T) {
+	pub1, _ := generateKey(t)
+	pub2, priv2 := generateKey(t)
+	manifest := []byte(`{"guid":"abc"}`)
+	sig := Sign(priv2, manifest)
+
+	tr := New([]ed25519.PublicKey{pub1, pub2}, ModeEnforce)
+	if err := tr.Check("abc", manifest, sig); err != nil {
+		t.Fatalf("Check: %v, want nil when the signature matches the second trust root", err)
+	}
+}
+
+func TestCheckEnforceRejectsMismatch(t *testing.T) {
+	pub, _ := generateKey(t)
+	_, otherPriv := generateKey(t)
+	manifest := []byte(`{"guid":"abc"}`)
+	sig := Sign(otherPriv, manifest)
+
+	tr := New([]ed25519.PublicKey{pub}, ModeEnforce)
+	err := tr.Check("abc", manifest, sig)
+	if err == nil {
+		t.Fatalf("Check accepted a signature from an untrusted key")
+	}
+	verr, ok := err.(*VerificationError)
+	if !ok {
+		t.Fatalf("err = %T, want *VerificationError", err)
+	}
+	if verr.ID != "abc" {
+		t.Fatalf("VerificationError.ID = %q, want %q", verr.ID, "abc")
+	}
+}
+
+func TestCheckEnforceRejectsTamperedManifest(t *testing.T) {
+	pub, priv := generateKey(t)
+	sig := Sign(priv, []byte(`{"guid":"abc"}`))
+
+	tr := New([]ed25519.PublicKey{pub}, ModeEnforce)
+	if err := tr.Check("abc", []byte(`{"guid":"xyz"}`), sig); err == nil {
+		t.Fatalf("Check accepted a signature over different bytes than the manifest")
+	}
+}
+
+func TestCheckWarnModeLogsAndReturnsNil(t *testing.T) {
+	pub, _ := generateKey(t)
+	_, otherPriv := generateKey(t)
+	manifest := []byte(`{"guid":"abc"}`)
+	sig := Sign(otherPriv, manifest)
+
+	tr := New([]ed25519.PublicKey{pub}, ModeWarn)
+	if err := tr.Check("abc", manifest, sig); err != nil {
+		t.Fatalf("Check in ModeWarn returned %v, want nil even on mismatch", err)
+	}
+}
+
+func TestParsePublicKeyRejectsWrongSize(t *testing.T) {
+	if _, err := ParsePublicKey([]byte{1, 2, 3}); err == nil {
+		t.Fatalf("ParsePublicKey accepted a key of the wrong size")
+	}
+}
+
+func TestParsePublicKeyAcceptsValidSize(t *testing.T) {
+	pub, _ := generateKey(t)
+	parsed, err := ParsePublicKey(pub)
+	if err != nil {
+		t.Fatalf("ParsePublicKey: %v", err)
+	}
+	if !parsed.Equal(pub) {
+		t.Fatalf("ParsePublicKey returned a different key than was passed in")
+	}
+}
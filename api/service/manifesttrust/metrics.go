@@ -0,0 +1,18 @@
+package manifesttrust
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	verifySuccess = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "oneseismic_manifesttrust_verify_success_total",
+		Help: "Number of manifest signature verifications that matched a trust root.",
+	})
+	verifyFailure = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "oneseismic_manifesttrust_verify_failure_total",
+		Help: "Number of manifest signature verifications that matched no trust root.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(verifySuccess, verifyFailure)
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultJWKSRefreshInterval is used when the JWKS endpoint's response
+// carries no Cache-Control max-age to derive one from.
+const defaultJWKSRefreshInterval = 10 * time.Minute
+
+// missingKidNegativeTTL bounds how often a kid that isn't in the keyset can
+// trigger an on-demand refresh, so a single bad token can't hammer the JWKS
+// endpoint.
+const missingKidNegativeTTL = time.Minute
+
+// OIDCKeySet is one issuer's JWKS, refreshed periodically in the background
+// and on demand when a token presents a kid it doesn't recognize yet.
+type OIDCKeySet struct {
+	authServer *url.URL
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	missing map[string]time.Time
+
+	refreshInterval time.Duration
+}
+
+type jwksDoc struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// GetOIDCKeySet fetches the JWKS served at authServer's well-known endpoint
+// and starts a background goroutine that refreshes it on an interval
+// derived from the response's Cache-Control max-age, falling back to
+// defaultJWKSRefreshInterval. The returned OIDCKeySet is safe for
+// concurrent use and keeps refreshing for the lifetime of the process.
+func GetOIDCKeySet(authServer *url.URL) (*OIDCKeySet, error) {
+	ks := &OIDCKeySet{
+		authServer:      authServer,
+		keys:            make(map[string]*rsa.PublicKey),
+		missing:         make(map[string]time.Time),
+		refreshInterval: defaultJWKSRefreshInterval,
+	}
+
+	if err := ks.refresh(); err != nil {
+		return nil, err
+	}
+
+	go ks.refreshLoop()
+	return ks, nil
+}
+
+func (ks *OIDCKeySet) jwksURL() string {
+	u := *ks.authServer
+	u.Path = strings.TrimRight(u.Path, "/") + "/.well-known/jwks.json"
+	return u.String()
+}
+
+func (ks *OIDCKeySet) refresh() error {
+	resp, err := http.Get(ks.jwksURL())
+	if err != nil {
+		return fmt.Errorf("fetching JWKS from %s: %v", ks.authServer, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching JWKS from %s: status=%d", ks.authServer, resp.StatusCode)
+	}
+
+	var doc jwksDoc
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("decoding JWKS from %s: %v", ks.authServer, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		key, err := rsaPublicKeyFromJWK(k.N, k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	interval := maxAgeOf(resp.Header.Get("Cache-Control"))
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.missing = make(map[string]time.Time)
+	if interval > 0 {
+		ks.refreshInterval = interval
+	}
+	ks.mu.Unlock()
+
+	return nil
+}
+
+func (ks *OIDCKeySet) refreshLoop() {
+	for {
+		ks.mu.Lock()
+		interval := ks.refreshInterval
+		ks.mu.Unlock()
+
+		time.Sleep(interval)
+		_ = ks.refresh() // a failed periodic refresh keeps serving the last known-good keyset
+	}
+}
+
+// Key returns the RSA public key for kid. A miss triggers one on-demand
+// refresh in case the key was rotated in since the last periodic refresh;
+// if it's still missing afterwards, it's negatively cached for
+// missingKidNegativeTTL before another refresh will be attempted for it.
+func (ks *OIDCKeySet) Key(kid string) (*rsa.PublicKey, bool) {
+	ks.mu.Lock()
+	key, ok := ks.keys[kid]
+	missedAt, recentlyMissing := ks.missing[kid]
+	ks.mu.Unlock()
+
+	if ok {
+		return key, true
+	}
+	if recentlyMissing && time.Since(missedAt) < missingKidNegativeTTL {
+		return nil, false
+	}
+
+	if err := ks.refresh(); err != nil {
+		return nil, false
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	key, ok = ks.keys[kid]
+	if !ok {
+		ks.missing[kid] = time.Now()
+	}
+	return key, ok
+}
+
+func rsaPublicKeyFromJWK(n, e string) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}, nil
+}
+
+// maxAgeOf extracts max-age from a Cache-Control header value, returning 0
+// when absent or unparsable.
+func maxAgeOf(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+		if err != nil {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}